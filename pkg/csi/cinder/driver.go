@@ -0,0 +1,97 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cinder implements a CSI plugin front-end for Cinder volumes. It
+// wraps the same pkg/flexvolume/cinder.CinderMounter used by the legacy
+// FlexVolume driver, so frakti nodes can be driven by either the FlexVolume
+// binary or the standard csi-node-driver-registrar sidecar.
+package cinder
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+)
+
+const (
+	driverName    = "cinder.csi.frakti.io"
+	driverVersion = "0.1.0"
+)
+
+// Driver is the CSI plugin entry point. It serves the Identity and Node
+// services; Controller is left to the out-of-tree Cinder CSI provisioner
+// and is not implemented here.
+type Driver struct {
+	endpoint string
+	nodeID   string
+}
+
+// NewDriver returns a CSI driver that will listen on endpoint and identify
+// the local node as nodeID in NodeGetInfo.
+func NewDriver(endpoint, nodeID string) *Driver {
+	return &Driver{
+		endpoint: endpoint,
+		nodeID:   nodeID,
+	}
+}
+
+// Run starts serving the CSI gRPC services and blocks until the listener
+// is closed.
+func (d *Driver) Run() error {
+	listener, err := newListener(d.endpoint)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	server := grpc.NewServer()
+	csi.RegisterIdentityServer(server, &identityServer{driver: d})
+	csi.RegisterNodeServer(server, &nodeServer{driver: d})
+
+	glog.V(2).Infof("CSI cinder driver %s listening on %s", driverVersion, d.endpoint)
+	return server.Serve(listener)
+}
+
+// newListener parses a unix:// or tcp:// endpoint and opens it, removing a
+// stale socket file first since the CSI sidecars expect the driver to own
+// the lifecycle of the unix socket.
+func newListener(endpoint string) (net.Listener, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSI endpoint %q: %v", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		addr := u.Path
+		if addr == "" {
+			addr = u.Opaque
+		}
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %v", addr, err)
+		}
+		return net.Listen("unix", addr)
+	case "tcp":
+		return net.Listen("tcp", u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported CSI endpoint scheme %q", u.Scheme)
+	}
+}
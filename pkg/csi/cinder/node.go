@@ -0,0 +1,160 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+
+	cindervolume "k8s.io/frakti/pkg/flexvolume/cinder"
+)
+
+type nodeServer struct {
+	driver *Driver
+}
+
+// volumeParams is the pure result of parsing the CSI volume attributes the
+// external Cinder CSI controller stashed in VolumeContext at provisioning
+// time, mirroring the cinderConfig magic file the FlexVolume driver reads
+// from jsonOptions. It is split out from mounterFromVolumeContext so the
+// parsing itself is testable without a live Cinder connection.
+type volumeParams struct {
+	volId        string
+	fsType       string
+	cinderConfig string
+	volumeMode   string
+}
+
+func volumeParamsFromContext(volCtx map[string]string, fsType string) (*volumeParams, error) {
+	volId, ok := volCtx["volumeID"]
+	if !ok || len(volId) == 0 {
+		return nil, fmt.Errorf("missing volumeID in volume context")
+	}
+
+	if len(fsType) == 0 {
+		fsType = volCtx["fsType"]
+	}
+
+	params := &volumeParams{
+		volId:        volId,
+		fsType:       fsType,
+		cinderConfig: volCtx["cinderConfig"],
+	}
+	if volCtx["volumeMode"] == cindervolume.VolumeModeBlock {
+		params.volumeMode = cindervolume.VolumeModeBlock
+	}
+
+	return params, nil
+}
+
+// mounterFromVolumeContext builds a cindervolume.CinderMounter from the CSI
+// volume attributes in volCtx.
+func mounterFromVolumeContext(volCtx map[string]string, fsType string) (*cindervolume.CinderMounter, error) {
+	params, err := volumeParamsFromContext(volCtx, fsType)
+	if err != nil {
+		return nil, err
+	}
+
+	mounter, err := cindervolume.NewCinderMounter(params.volId, params.fsType, params.cinderConfig)
+	if err != nil {
+		return nil, err
+	}
+	mounter.VolumeMode = params.volumeMode
+
+	return mounter, nil
+}
+
+// NodePublishVolume attaches and mounts a Cinder volume at req.TargetPath,
+// delegating to the same CinderMounter the FlexVolume driver's mount()
+// uses.
+func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	if len(targetPath) == 0 {
+		return nil, fmt.Errorf("target path missing in request")
+	}
+
+	mounter, err := mounterFromVolumeContext(req.GetVolumeContext(), req.GetVolumeCapability().GetMount().GetFsType())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetVolumeCapability().GetBlock() != nil {
+		mounter.VolumeMode = cindervolume.VolumeModeBlock
+	}
+	mounter.ReadOnly = req.GetReadonly()
+
+	if err := mounter.Mount(targetPath); err != nil {
+		glog.V(4).Infof("CSI NodePublishVolume: mount failed: %v", err)
+		return nil, err
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume unmounts and detaches the Cinder volume previously
+// published at req.TargetPath, delegating to the same CinderMounter the
+// FlexVolume driver's unmount() uses.
+func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	targetPath := req.GetTargetPath()
+	if len(targetPath) == 0 {
+		return nil, fmt.Errorf("target path missing in request")
+	}
+
+	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
+		// NodeUnpublishVolume must be idempotent: the volume is already
+		// not published at targetPath, so there is nothing to do.
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+
+	mounter, err := cindervolume.NewCinderMounterFromMountDir(targetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mounter.Unmount(targetPath); err != nil {
+		return nil, err
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (s *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: s.driver.nodeID}, nil
+}
+
+func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	return nil, fmt.Errorf("NodeStageVolume is not implemented, frakti cinder volumes are mounted directly in NodePublishVolume")
+}
+
+func (s *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	return nil, fmt.Errorf("NodeUnstageVolume is not implemented, frakti cinder volumes are mounted directly in NodePublishVolume")
+}
+
+func (s *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, fmt.Errorf("NodeGetVolumeStats is not implemented")
+}
+
+func (s *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return nil, fmt.Errorf("NodeExpandVolume is not implemented")
+}
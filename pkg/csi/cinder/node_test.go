@@ -0,0 +1,86 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"testing"
+
+	cindervolume "k8s.io/frakti/pkg/flexvolume/cinder"
+)
+
+func TestVolumeParamsFromContextMissingVolumeID(t *testing.T) {
+	if _, err := volumeParamsFromContext(map[string]string{}, "ext4"); err == nil {
+		t.Fatal("volumeParamsFromContext with no volumeID: expected error, got nil")
+	}
+}
+
+func TestVolumeParamsFromContext(t *testing.T) {
+	volCtx := map[string]string{
+		"volumeID":     "vol-1",
+		"cinderConfig": "/etc/cinder/cinder.conf",
+	}
+
+	params, err := volumeParamsFromContext(volCtx, "ext4")
+	if err != nil {
+		t.Fatalf("volumeParamsFromContext returned error: %v", err)
+	}
+	if params.volId != "vol-1" {
+		t.Errorf("volId = %q, want %q", params.volId, "vol-1")
+	}
+	if params.fsType != "ext4" {
+		t.Errorf("fsType = %q, want %q", params.fsType, "ext4")
+	}
+	if params.cinderConfig != "/etc/cinder/cinder.conf" {
+		t.Errorf("cinderConfig = %q, want %q", params.cinderConfig, "/etc/cinder/cinder.conf")
+	}
+	if params.volumeMode != "" {
+		t.Errorf("volumeMode = %q, want empty for a filesystem volume", params.volumeMode)
+	}
+}
+
+// TestVolumeParamsFromContextFsTypeFallback makes sure the volume context's
+// fsType is used as a fallback when the request's own VolumeCapability
+// carries no fsType (passed in as empty).
+func TestVolumeParamsFromContextFsTypeFallback(t *testing.T) {
+	volCtx := map[string]string{
+		"volumeID": "vol-1",
+		"fsType":   "xfs",
+	}
+
+	params, err := volumeParamsFromContext(volCtx, "")
+	if err != nil {
+		t.Fatalf("volumeParamsFromContext returned error: %v", err)
+	}
+	if params.fsType != "xfs" {
+		t.Errorf("fsType = %q, want fallback %q", params.fsType, "xfs")
+	}
+}
+
+func TestVolumeParamsFromContextBlockMode(t *testing.T) {
+	volCtx := map[string]string{
+		"volumeID":   "vol-1",
+		"volumeMode": cindervolume.VolumeModeBlock,
+	}
+
+	params, err := volumeParamsFromContext(volCtx, "")
+	if err != nil {
+		t.Fatalf("volumeParamsFromContext returned error: %v", err)
+	}
+	if params.volumeMode != cindervolume.VolumeModeBlock {
+		t.Errorf("volumeMode = %q, want %q", params.volumeMode, cindervolume.VolumeModeBlock)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// VolumeModeFilesystem is the default volumeMode: AttachDisk formats
+	// and mounts the Cinder volume as a filesystem at the target dir.
+	VolumeModeFilesystem = "Filesystem"
+	// VolumeModeBlock exposes the Cinder volume to the container as a raw
+	// block device instead of a mounted filesystem.
+	VolumeModeBlock = "Block"
+
+	// byIDDir is where Nova's libvirt driver creates a stable udev symlink
+	// for each virtio-attached Cinder volume, named after the volume ID.
+	byIDDir = "/dev/disk/by-id"
+)
+
+// deviceByIDPath returns the udev by-id symlink Nova creates for a
+// virtio-attached Cinder volume: /dev/disk/by-id/virtio-<volID, truncated to
+// 20 chars>. This is stable across reboots and hot-unplug/replug, unlike the
+// /dev/vdX name the kernel happens to assign, so it doesn't depend on
+// AttachDisk recording anything extra for us.
+func deviceByIDPath(volID string) string {
+	id := volID
+	if len(id) > 20 {
+		id = id[:20]
+	}
+	return filepath.Join(byIDDir, "virtio-"+id)
+}
+
+// MountDevice exposes the Cinder volume backing m as a raw block device at
+// targetDir, by symlinking its stable by-id device path. It is used instead
+// of the regular mkfs+mount path when m.VolumeMode is VolumeModeBlock.
+func (fm *FlexManager) MountDevice(m *CinderMounter, targetDir string) error {
+	device := deviceByIDPath(m.VolId)
+	if _, err := os.Stat(device); err != nil {
+		return fmt.Errorf("cinder volume %s has no attached device at %s: %v", m.VolId, device, err)
+	}
+
+	if err := os.Remove(targetDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to clear target path %s: %v", targetDir, err)
+	}
+
+	if err := os.Symlink(device, targetDir); err != nil {
+		return fmt.Errorf("failed to symlink %s to %s: %v", device, targetDir, err)
+	}
+
+	glog.V(3).Infof("Cinder volume %s device %s exposed as block device at %s", m.VolId, device, targetDir)
+	return nil
+}
+
+// UnmountDevice removes the block device symlink MountDevice created at
+// targetDir, before DetachDisk is called to detach the volume itself.
+func (fm *FlexManager) UnmountDevice(m *CinderMounter, targetDir string) error {
+	if err := os.Remove(targetDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove block device symlink %s: %v", targetDir, err)
+	}
+	return nil
+}
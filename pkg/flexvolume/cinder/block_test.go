@@ -0,0 +1,35 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import "testing"
+
+func TestDeviceByIDPath(t *testing.T) {
+	cases := []struct {
+		volID string
+		want  string
+	}{
+		{"short-id", "/dev/disk/by-id/virtio-short-id"},
+		{"12345678901234567890extra", "/dev/disk/by-id/virtio-12345678901234567890"},
+	}
+
+	for _, c := range cases {
+		if got := deviceByIDPath(c.volID); got != c.want {
+			t.Errorf("deviceByIDPath(%q) = %q, want %q", c.volID, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/extensions/volumeactions"
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v2/volumes"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	volumeAvailable = "available"
+	volumeInUse     = "in-use"
+
+	expandPollInterval = 2 * time.Second
+	expandPollTimeout  = 2 * time.Minute
+)
+
+// ExpandVolume grows the Cinder volume identified by volID to newSizeGB
+// gigabytes via the Cinder os-extend action, mirroring what upstream
+// Kubernetes' in-tree Cinder provisioner does for online resize. It blocks
+// until the volume has settled back into "available" or "in-use" before
+// returning, so a subsequent ExpandFS sees the resized device.
+func (m *FlexManager) ExpandVolume(volID string, newSizeGB int) error {
+	glog.V(4).Infof("Expanding cinder volume %s to %dGB", volID, newSizeGB)
+
+	if err := m.extendVolume(volID, newSizeGB); err != nil {
+		return fmt.Errorf("failed to extend cinder volume %s: %v", volID, err)
+	}
+
+	return wait.PollImmediate(expandPollInterval, expandPollTimeout, func() (bool, error) {
+		status, err := m.volumeStatus(volID)
+		if err != nil {
+			return false, err
+		}
+		switch status {
+		case volumeAvailable, volumeInUse:
+			return true, nil
+		default:
+			glog.V(5).Infof("cinder volume %s still resizing, status %q", volID, status)
+			return false, nil
+		}
+	})
+}
+
+// ExpandFS grows the filesystem of fsType mounted at mountDir to use the
+// full, already-expanded size of the underlying device, via resize2fs for
+// ext2/3/4 or xfs_growfs for xfs.
+func (m *FlexManager) ExpandFS(volID, mountDir, fsType string, newSizeGB int) error {
+	glog.V(4).Infof("Growing %s filesystem at %s for cinder volume %s", fsType, mountDir, volID)
+
+	var cmd *exec.Cmd
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		device, err := m.deviceForMount(mountDir)
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("resize2fs", device)
+	case "xfs":
+		cmd = exec.Command("xfs_growfs", mountDir)
+	default:
+		return fmt.Errorf("online filesystem resize is not supported for fsType %q", fsType)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to resize %s filesystem at %s: %v: %s", fsType, mountDir, err, out)
+	}
+
+	return nil
+}
+
+// extendVolume issues the Cinder os-extend action against volID, asking it
+// to grow to newSizeGB gigabytes.
+func (m *FlexManager) extendVolume(volID string, newSizeGB int) error {
+	return volumeactions.ExtendSize(m.client, volID, volumeactions.ExtendSizeOpts{
+		NewSize: newSizeGB,
+	}).ExtractErr()
+}
+
+// volumeStatus returns the current Cinder status (e.g. "available",
+// "in-use", "extending") of volID.
+func (m *FlexManager) volumeStatus(volID string) (string, error) {
+	vol, err := volumes.Get(m.client, volID).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cinder volume %s: %v", volID, err)
+	}
+	return vol.Status, nil
+}
+
+// deviceForMount resolves the backing block device of mountDir, needed by
+// resize2fs which (unlike xfs_growfs) takes a device path rather than a
+// mount point.
+func (m *FlexManager) deviceForMount(mountDir string) (string, error) {
+	out, err := exec.Command("findmnt", "-n", "-o", "SOURCE", "--target", mountDir).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve device for %s: %v: %s", mountDir, err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
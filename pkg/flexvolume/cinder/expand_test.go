@@ -0,0 +1,31 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import "testing"
+
+// TestExpandFSUnsupportedFsType makes sure ExpandFS rejects an fsType it
+// doesn't know how to grow before it ever touches the Cinder client or
+// shells out to resize2fs/xfs_growfs.
+func TestExpandFSUnsupportedFsType(t *testing.T) {
+	var m *FlexManager
+
+	err := m.ExpandFS("vol-1", "/mnt/vol-1", "btrfs", 10)
+	if err == nil {
+		t.Fatal("ExpandFS with an unsupported fsType: expected error, got nil")
+	}
+}
@@ -21,24 +21,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"syscall"
 
 	"github.com/golang/glog"
 	"k8s.io/frakti/pkg/flexvolume"
-	utilmetadata "k8s.io/frakti/pkg/util/metadata"
 )
 
 type FlexVolumeDriver struct {
 	uuid string
 	name string
 
-	volId        string
-	fsType       string
-	cinderConfig string
-	readOnly     bool
-	manager      *FlexManager
-
-	// metadata provides meta of the volume
-	metadata map[string]interface{}
+	mounter *CinderMounter
 }
 
 // NewFlexVolumeDriver returns a flex volume driver
@@ -54,7 +48,10 @@ func (d *FlexVolumeDriver) init() (map[string]interface{}, error) {
 	// "{\"status\": \"Success\", \"capabilities\": {\"attach\": false}}"
 	return map[string]interface{}{
 		"capabilities": map[string]bool{
-			"attach": false,
+			"attach":            false,
+			"requiresFSResize":  true,
+			"supportsMetrics":   true,
+			"supportsBlockMode": true,
 		},
 	}, nil
 }
@@ -68,23 +65,25 @@ func (d *FlexVolumeDriver) initFlexVolumeDriverForMount(jsonOptions string) erro
 		return fmt.Errorf("jsonOptions is not set by user properly: %#v", jsonOptions)
 	}
 
-	// cinder configure file is optional in jsonOptions
-	if userConfig, ok := volOptions[flexvolume.CinderConfigKey]; ok {
-		d.cinderConfig = userConfig.(string)
-	} else {
-		// use default configure if not provided
-		d.cinderConfig = flexvolume.CinderConfigFile
-	}
-
-	d.volId = volOptions[flexvolume.VolIdKey].(string)
+	volId := volOptions[flexvolume.VolIdKey].(string)
 	// this is a system option
-	d.fsType = volOptions["kubernetes.io/fsType"].(string)
+	fsType := volOptions["kubernetes.io/fsType"].(string)
 
-	manager, err := NewFlexManager(d.cinderConfig)
+	// cinder configure file is optional in jsonOptions
+	cinderConfig, _ := volOptions[flexvolume.CinderConfigKey].(string)
+
+	mounter, err := NewCinderMounter(volId, fsType, cinderConfig)
 	if err != nil {
 		return err
 	}
-	d.manager = manager
+
+	// kubernetes.io/volumeMode is only set for Block volumes; a plain
+	// filesystem PVC omits it entirely.
+	if volumeMode, ok := volOptions["kubernetes.io/volumeMode"].(string); ok && volumeMode == VolumeModeBlock {
+		mounter.VolumeMode = VolumeModeBlock
+	}
+
+	d.mounter = mounter
 
 	return nil
 }
@@ -92,21 +91,11 @@ func (d *FlexVolumeDriver) initFlexVolumeDriverForMount(jsonOptions string) erro
 // initFlexVolumeDriverForUnMount use targetMountDir to initialize FlexVolumeDriver from magic file
 func (d *FlexVolumeDriver) initFlexVolumeDriverForUnMount(targetMountDir string) error {
 	// use the magic file to store volId since flexvolume will execute fresh new binary every time
-	var optsData flexvolume.FlexVolumeOptsData
-	err := flexvolume.ReadJsonOptsFile(targetMountDir, &optsData)
+	mounter, err := NewCinderMounterFromMountDir(targetMountDir)
 	if err != nil {
 		return err
 	}
-
-	d.cinderConfig = optsData.CinderData.ConfigKey
-
-	d.volId = optsData.CinderData.VolumeID
-
-	manager, err := NewFlexManager(d.cinderConfig)
-	if err != nil {
-		return err
-	}
-	d.manager = manager
+	d.mounter = mounter
 
 	return nil
 }
@@ -135,103 +124,116 @@ func (d *FlexVolumeDriver) isAttached(jsonOptions, nodeName string) (map[string]
 // mount will:
 // 1. attach Cinder volume to target dir by AttachDisk
 // 2. store meta data generated by AttachDisk into a json file in target dir
+// Both steps are delegated to a CinderMounter, which is also used by the
+// CSI node server in pkg/csi/cinder so the two entry points share one
+// implementation.
 func (d *FlexVolumeDriver) mount(targetMountDir, jsonOptions string) (map[string]interface{}, error) {
-	glog.V(5).Infof("Cinder flexvolume mount %s to %s", d.volId, targetMountDir)
+	glog.V(5).Infof("Cinder flexvolume mount to %s", targetMountDir)
 
-	// initialize cinder driver from user provided jsonOptions
+	// initialize cinder mounter from user provided jsonOptions
 	if err := d.initFlexVolumeDriverForMount(jsonOptions); err != nil {
 		return nil, err
 	}
 
-	// attach cinder disk to host machine
-	if err := d.manager.AttachDisk(d, targetMountDir); err != nil {
-		glog.V(4).Infof("AttachDisk failed: %v", err)
-		return nil, err
-	}
-	glog.V(3).Infof("Cinder volume %s attached", d.volId)
-
-	// append VolumeOptions with metadata
-	optsData := &flexvolume.FlexVolumeOptsData{
-		CinderData: d.generateOptionsData(d.metadata),
-	}
-	// create a file and write metadata into the it
-	if err := flexvolume.WriteJsonOptsFile(targetMountDir, optsData); err != nil {
-		os.Remove(targetMountDir)
-		detachDiskLogError(d)
+	if err := d.mounter.Mount(targetMountDir); err != nil {
 		return nil, err
 	}
 
 	return nil, nil
 }
 
-func (d *FlexVolumeDriver) generateOptionsData(metadata map[string]interface{}) *flexvolume.CinderVolumeOptsData {
-	var result *flexvolume.CinderVolumeOptsData
-
-	result.ConfigKey = d.cinderConfig
-	result.VolumeID = d.volId
-	result.FsType = d.fsType
+// Invocation: <driver executable> unmount <mount dir>
+func (d *FlexVolumeDriver) unmount(targetMountDir string) (map[string]interface{}, error) {
+	glog.V(5).Infof("Cinder flexvolume unmount of %s", targetMountDir)
 
-	if data, ok := metadata["volume_type"]; ok {
-		result.VolumeType = data.(string)
-	}
-	if data, ok := metadata["name"]; ok {
-		result.Name = data.(string)
+	// check the target directory
+	if _, err := os.Stat(targetMountDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("volume directory: %v does not exists", targetMountDir)
 	}
 
-	if data, ok := metadata["hosts"]; ok {
-		if hosts, err := utilmetadata.ExtractStringSlice(data); err != nil {
-			glog.V(4).Infof("cannot parse metadata hosts: %v", err)
-		} else {
-			result.Hosts = hosts
-		}
+	// initialize the cinder mounter by reading cinderConfig from metadata file
+	if err := d.initFlexVolumeDriverForUnMount(targetMountDir); err != nil {
+		return nil, err
 	}
 
-	if data, ok := metadata["ports"]; ok {
-		if ports, err := utilmetadata.ExtractStringSlice(data); err != nil {
-			glog.V(4).Infof("cannot parse metadata ports: %v", err)
-		} else {
-			result.Ports = ports
-		}
+	if err := d.mounter.Unmount(targetMountDir); err != nil {
+		return nil, err
 	}
 
-	return result
+	return nil, nil
 }
 
-// detachDiskLogError is a wrapper to detach first before log error
-func detachDiskLogError(d *FlexVolumeDriver) {
-	err := d.manager.DetachDisk(d)
-	if err != nil {
-		glog.Warningf("Failed to detach disk: %v (%v)", d, err)
+// Invocation: <driver executable> expandvolume <json options> <new size>
+// expandVolume grows the backing Cinder volume to newSize (in GiB) by
+// issuing the Cinder os-extend API call and waiting for the volume to
+// settle back into available/in-use.
+func (d *FlexVolumeDriver) expandVolume(jsonOptions, newSize string) (map[string]interface{}, error) {
+	if err := d.initFlexVolumeDriverForMount(jsonOptions); err != nil {
+		return nil, err
 	}
-}
 
-// Invocation: <driver executable> unmount <mount dir>
-func (d *FlexVolumeDriver) unmount(targetMountDir string) (map[string]interface{}, error) {
-	glog.V(5).Infof("Cinder flexvolume unmount of %s", targetMountDir)
-
-	// check the target directory
-	if _, err := os.Stat(targetMountDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("volume directory: %v does not exists", targetMountDir)
+	size, err := strconv.Atoi(newSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid new size %q: %v", newSize, err)
 	}
 
-	//  initialize FlexVolumeDriver manager by reading cinderConfig from metadata file
-	if err := d.initFlexVolumeDriverForUnMount(targetMountDir); err != nil {
+	if err := d.mounter.manager.ExpandVolume(d.mounter.VolId, size); err != nil {
 		return nil, err
 	}
 
-	if err := d.manager.DetachDisk(d); err != nil {
+	return nil, nil
+}
+
+// Invocation: <driver executable> expandfs <mount dir> <json options> <new size>
+// expandFS grows the filesystem on the device mounted at mountDir to match
+// the Cinder volume's new size, once expandVolume has already resized the
+// volume itself.
+func (d *FlexVolumeDriver) expandFS(mountDir, jsonOptions, newSize string) (map[string]interface{}, error) {
+	if err := d.initFlexVolumeDriverForMount(jsonOptions); err != nil {
 		return nil, err
 	}
 
-	// NOTE: the targetDir will be cleaned by flexvolume,
-	// we just need to clean up the metadata file.
-	if err := flexvolume.CleanUpMetadataFile(targetMountDir); err != nil {
+	size, err := strconv.Atoi(newSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid new size %q: %v", newSize, err)
+	}
+
+	if err := d.mounter.manager.ExpandFS(d.mounter.VolId, mountDir, d.mounter.FsType, size); err != nil {
 		return nil, err
 	}
 
 	return nil, nil
 }
 
+// Invocation: <driver executable> getvolumestats <mount dir>
+// getVolumeStats reports capacity, usage and inode statistics for the
+// filesystem mounted at mountDir via statfs(2), mirroring what
+// pkg/volume/util/fs.go does in upstream Kubernetes, so kubelet can
+// populate kubelet_volume_stats_* metrics for hypervisor-backed pods.
+func (d *FlexVolumeDriver) getVolumeStats(mountDir string) (map[string]interface{}, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(mountDir, &stat); err != nil {
+		return nil, fmt.Errorf("failed to statfs %s: %v", mountDir, err)
+	}
+
+	capacity := int64(stat.Blocks) * int64(stat.Bsize)
+	available := int64(stat.Bavail) * int64(stat.Bsize)
+	used := capacity - available
+
+	inodesTotal := int64(stat.Files)
+	inodesFree := int64(stat.Ffree)
+	inodesUsed := inodesTotal - inodesFree
+
+	return map[string]interface{}{
+		"capacity_bytes":  capacity,
+		"available_bytes": available,
+		"used_bytes":      used,
+		"inodes_total":    inodesTotal,
+		"inodes_free":     inodesFree,
+		"inodes_used":     inodesUsed,
+	}, nil
+}
+
 type driverOp func(*FlexVolumeDriver, []string) (map[string]interface{}, error)
 
 type cmdInfo struct {
@@ -275,6 +277,21 @@ var commands = map[string]cmdInfo{
 			return d.unmount(args[0])
 		},
 	},
+	"expandvolume": {
+		2, func(d *FlexVolumeDriver, args []string) (map[string]interface{}, error) {
+			return d.expandVolume(args[0], args[1])
+		},
+	},
+	"expandfs": {
+		3, func(d *FlexVolumeDriver, args []string) (map[string]interface{}, error) {
+			return d.expandFS(args[0], args[1], args[2])
+		},
+	},
+	"getvolumestats": {
+		1, func(d *FlexVolumeDriver, args []string) (map[string]interface{}, error) {
+			return d.getVolumeStats(args[0])
+		},
+	},
 }
 
 func (d *FlexVolumeDriver) doRun(args []string) (map[string]interface{}, error) {
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestGetVolumeStats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "frakti-cinder-stats")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	d := &FlexVolumeDriver{}
+
+	stats, err := d.getVolumeStats(tmpDir)
+	if err != nil {
+		t.Fatalf("getVolumeStats returned error: %v", err)
+	}
+
+	capacity := stats["capacity_bytes"].(int64)
+	available := stats["available_bytes"].(int64)
+	used := stats["used_bytes"].(int64)
+
+	if capacity <= 0 {
+		t.Errorf("capacity_bytes = %d, want > 0", capacity)
+	}
+	if available > capacity {
+		t.Errorf("available_bytes = %d, want <= capacity_bytes (%d)", available, capacity)
+	}
+	if want := capacity - available; used != want {
+		t.Errorf("used_bytes = %d, want capacity_bytes - available_bytes (%d)", used, want)
+	}
+
+	inodesTotal := stats["inodes_total"].(int64)
+	inodesFree := stats["inodes_free"].(int64)
+	inodesUsed := stats["inodes_used"].(int64)
+	if want := inodesTotal - inodesFree; inodesUsed != want {
+		t.Errorf("inodes_used = %d, want inodes_total - inodes_free (%d)", inodesUsed, want)
+	}
+}
+
+func TestGetVolumeStatsMissingDir(t *testing.T) {
+	d := &FlexVolumeDriver{}
+
+	if _, err := d.getVolumeStats("/no/such/directory"); err == nil {
+		t.Error("getVolumeStats on a missing directory: expected error, got nil")
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// staleStateLease is how long an in-progress state (mounting/detaching/...)
+// is trusted before it is considered abandoned regardless of whether the
+// pid that recorded it is still alive. pid liveness alone cannot detect a
+// stuck operation in a long-lived process such as the CSI node server,
+// which reuses the same pid across every NodePublishVolume/
+// NodeUnpublishVolume call, so a failed operation there would otherwise
+// wedge the volume forever.
+const staleStateLease = 5 * time.Minute
+
+// volumeState is a step of the per-volume attach/mount state machine. It is
+// persisted to disk so it survives the fresh re-exec flexvolume performs
+// for every operation.
+type volumeState string
+
+const (
+	stateAttaching  volumeState = "attaching"
+	stateAttached   volumeState = "attached"
+	stateMounting   volumeState = "mounting"
+	stateMounted    volumeState = "mounted"
+	stateUnmounting volumeState = "unmounting"
+	stateDetaching  volumeState = "detaching"
+)
+
+// volumeStateFile is the on-disk record of a volume's current state and the
+// pid of the process that last transitioned it, used to detect a crashed
+// operation that left stale state behind.
+type volumeStateFile struct {
+	State     volumeState `json:"state"`
+	Pid       int         `json:"pid"`
+	UpdatedAt int64       `json:"updatedAt"`
+}
+
+// volumeLock serializes attach/detach/mount/unmount operations against a
+// single Cinder volume across the independent processes flexvolume re-execs
+// for every call, via an flock(2) on a per-volume lock file plus the state
+// file above. This closes races like kubernetes/kubernetes#71145, where a
+// volume was detached while a mount was still in progress.
+type volumeLock struct {
+	volID   string
+	lockDir string
+
+	file *os.File
+}
+
+// newVolumeLock returns the lock for volID, rooted next to cinderConfig so
+// it is visible to every invocation sharing that configuration.
+func newVolumeLock(cinderConfig, volID string) *volumeLock {
+	return &volumeLock{
+		volID:   volID,
+		lockDir: filepath.Join(filepath.Dir(cinderConfig), "locks"),
+	}
+}
+
+func (l *volumeLock) lockPath() string {
+	return filepath.Join(l.lockDir, l.volID+".lock")
+}
+
+func (l *volumeLock) statePath() string {
+	return filepath.Join(l.lockDir, l.volID+".state")
+}
+
+// acquire takes the exclusive flock for volID, blocking until it is free,
+// validates that transitioning to next is safe given any in-progress
+// operation, and records next as the volume's new state. Callers must call
+// release once the operation finishes.
+func (l *volumeLock) acquire(next volumeState) error {
+	if err := os.MkdirAll(l.lockDir, 0750); err != nil {
+		return fmt.Errorf("failed to create lock dir %s: %v", l.lockDir, err)
+	}
+
+	f, err := os.OpenFile(l.lockPath(), os.O_CREATE|os.O_RDWR, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file for volume %s: %v", l.volID, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to lock volume %s: %v", l.volID, err)
+	}
+	l.file = f
+
+	cur, err := l.readState()
+	if err != nil {
+		l.release()
+		return err
+	}
+
+	if cur != nil && !l.cleanupIfStale(cur) && !transitionAllowed(cur.State, next) {
+		l.release()
+		return fmt.Errorf("volume %s is %s, refusing to transition to %s", l.volID, cur.State, next)
+	}
+
+	if err := l.writeNewState(next); err != nil {
+		l.release()
+		return err
+	}
+
+	return nil
+}
+
+// transition records next as the volume's state without releasing the
+// flock, for moving e.g. mounting -> mounted while still holding the lock
+// taken by acquire.
+func (l *volumeLock) transition(next volumeState) error {
+	return l.writeNewState(next)
+}
+
+// writeNewState stamps next with the current pid and time and persists it.
+func (l *volumeLock) writeNewState(next volumeState) error {
+	return l.writeState(&volumeStateFile{State: next, Pid: os.Getpid(), UpdatedAt: time.Now().Unix()})
+}
+
+// clear removes the state file, returning the volume to the "no state
+// recorded" starting point. Callers must still hold the lock taken by
+// acquire. Used once an operation (e.g. detach) leaves the volume with no
+// state of its own to track.
+func (l *volumeLock) clear() {
+	if err := os.Remove(l.statePath()); err != nil && !os.IsNotExist(err) {
+		glog.Warningf("failed to clear state file for volume %s: %v", l.volID, err)
+	}
+}
+
+// release drops the flock taken by acquire.
+func (l *volumeLock) release() error {
+	if l.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *volumeLock) readState() (*volumeStateFile, error) {
+	data, err := ioutil.ReadFile(l.statePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file for volume %s: %v", l.volID, err)
+	}
+
+	var state volumeStateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file for volume %s: %v", l.volID, err)
+	}
+	return &state, nil
+}
+
+func (l *volumeLock) writeState(state *volumeStateFile) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state for volume %s: %v", l.volID, err)
+	}
+	return ioutil.WriteFile(l.statePath(), data, 0640)
+}
+
+// cleanupIfStale clears cur and returns true if either the pid that
+// recorded it is no longer alive, or it has sat unchanged for longer than
+// staleStateLease. The latter check is what lets a long-lived process like
+// the CSI node server self-heal: its pid never dies between calls, so pid
+// liveness alone would never flag a state a failed operation left behind.
+func (l *volumeLock) cleanupIfStale(cur *volumeStateFile) bool {
+	deadPid := cur.Pid != 0 && !processAlive(cur.Pid)
+	expired := cur.UpdatedAt != 0 && time.Since(time.Unix(cur.UpdatedAt, 0)) > staleStateLease
+	if !deadPid && !expired {
+		return false
+	}
+	glog.Warningf("volume %s has stale state %q (pid %d, updated %s ago), clearing", l.volID, cur.State, cur.Pid, time.Since(time.Unix(cur.UpdatedAt, 0)))
+	os.Remove(l.statePath())
+	return true
+}
+
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// transitionAllowed reports whether moving from cur to next is safe. It
+// exists mainly to refuse a detach while a mount is still in flight for the
+// same volume; a volume that already finished mounting must still be free
+// to detach normally.
+func transitionAllowed(cur, next volumeState) bool {
+	switch cur {
+	case stateMounting, stateAttaching:
+		return next != stateDetaching
+	case stateDetaching, stateUnmounting:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTransitionAllowed(t *testing.T) {
+	cases := []struct {
+		name string
+		cur  volumeState
+		next volumeState
+		want bool
+	}{
+		{"mounting to detaching is refused", stateMounting, stateDetaching, false},
+		{"attaching to detaching is refused", stateAttaching, stateDetaching, false},
+		{"mounted to detaching is allowed", stateMounted, stateDetaching, true},
+		{"attached to detaching is allowed", stateAttached, stateDetaching, true},
+		{"mounting to mounted is allowed", stateMounting, stateMounted, true},
+		{"detaching to anything is refused", stateDetaching, stateMounting, false},
+		{"unmounting to anything is refused", stateUnmounting, stateAttaching, false},
+		{"empty state allows anything", volumeState(""), stateMounting, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := transitionAllowed(c.cur, c.next); got != c.want {
+				t.Errorf("transitionAllowed(%q, %q) = %v, want %v", c.cur, c.next, got, c.want)
+			}
+		})
+	}
+}
+
+// TestCleanupIfStaleExpiresByLease makes sure a state recorded by a pid
+// that is still alive (as a long-lived caller like the CSI node server
+// always is) is still cleaned up once it is older than staleStateLease,
+// since pid liveness alone can never detect a stuck operation there.
+func TestCleanupIfStaleExpiresByLease(t *testing.T) {
+	lockDir, err := ioutil.TempDir("", "frakti-cinder-lock")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(lockDir)
+
+	l := &volumeLock{volID: "vol-1", lockDir: lockDir}
+
+	cur := &volumeStateFile{
+		State:     stateDetaching,
+		Pid:       os.Getpid(),
+		UpdatedAt: time.Now().Add(-2 * staleStateLease).Unix(),
+	}
+	if err := l.writeState(cur); err != nil {
+		t.Fatalf("failed to seed state file: %v", err)
+	}
+
+	if !l.cleanupIfStale(cur) {
+		t.Error("cleanupIfStale() = false, want true for an expired lease even with a live pid")
+	}
+	if _, err := os.Stat(l.statePath()); !os.IsNotExist(err) {
+		t.Errorf("state file still exists after cleanupIfStale: %v", err)
+	}
+}
+
+func TestCleanupIfStaleKeepsFreshState(t *testing.T) {
+	lockDir, err := ioutil.TempDir("", "frakti-cinder-lock")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(lockDir)
+
+	l := &volumeLock{volID: "vol-1", lockDir: lockDir}
+
+	cur := &volumeStateFile{
+		State:     stateMounting,
+		Pid:       os.Getpid(),
+		UpdatedAt: time.Now().Unix(),
+	}
+	if err := l.writeState(cur); err != nil {
+		t.Fatalf("failed to seed state file: %v", err)
+	}
+
+	if l.cleanupIfStale(cur) {
+		t.Error("cleanupIfStale() = true, want false for a fresh state with a live pid")
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+	"k8s.io/frakti/pkg/flexvolume"
+	utilmetadata "k8s.io/frakti/pkg/util/metadata"
+)
+
+// CinderMounter implements the actual attach/mount and unmount/detach logic
+// against a Cinder volume. It is exported so it can be shared by both the
+// legacy FlexVolume driver in this package and the CSI node server in
+// pkg/csi/cinder, keeping a single implementation behind the two entry
+// points.
+type CinderMounter struct {
+	VolId        string
+	FsType       string
+	CinderConfig string
+	ReadOnly     bool
+	// VolumeMode is VolumeModeBlock for a block volume, set from the
+	// kubernetes.io/volumeMode option or the CSI volume context/capability;
+	// any other value, including the empty default, means a filesystem
+	// volume. Block volumes are exposed as a raw device symlink instead of
+	// a mounted filesystem.
+	VolumeMode string
+
+	manager *FlexManager
+
+	// metadata provides meta of the volume, populated by AttachDisk
+	metadata map[string]interface{}
+}
+
+// NewCinderMounter creates a CinderMounter for volId. If cinderConfig is
+// empty, the default configuration file is used.
+func NewCinderMounter(volId, fsType, cinderConfig string) (*CinderMounter, error) {
+	if len(cinderConfig) == 0 {
+		cinderConfig = flexvolume.CinderConfigFile
+	}
+
+	manager, err := NewFlexManager(cinderConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CinderMounter{
+		VolId:        volId,
+		FsType:       fsType,
+		CinderConfig: cinderConfig,
+		manager:      manager,
+	}, nil
+}
+
+// NewCinderMounterFromMountDir recovers a CinderMounter for the volume
+// previously mounted at targetDir, by reading the metadata file mount left
+// behind. This is needed because flexvolume re-execs a fresh binary for
+// every operation, so volId and friends don't survive in memory between
+// mount and unmount; CSI's NodeUnpublishVolume recovers them the same way.
+func NewCinderMounterFromMountDir(targetDir string) (*CinderMounter, error) {
+	var optsData flexvolume.FlexVolumeOptsData
+	if err := flexvolume.ReadJsonOptsFile(targetDir, &optsData); err != nil {
+		return nil, err
+	}
+
+	mounter, err := NewCinderMounter(optsData.CinderData.VolumeID, optsData.CinderData.FsType, optsData.CinderData.ConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	mounter.VolumeMode = optsData.CinderData.VolumeMode
+
+	return mounter, nil
+}
+
+// Mount attaches the Cinder volume to the host and mounts it at targetDir,
+// then persists a metadata file under targetDir so a later Unmount (running
+// in its own freshly exec'ed process) can recover enough state to detach it.
+// The whole sequence runs under a per-volume lock so a concurrent Unmount
+// for the same volume cannot detach it out from under an in-progress mount.
+// On any failure the lock's state is cleared rather than left at
+// "mounting", so the next Mount attempt (including a retry from a
+// long-lived caller like the CSI node server, which can't rely on
+// cleanupIfStale's pid check) isn't refused by its own leftover state.
+func (m *CinderMounter) Mount(targetDir string) (err error) {
+	glog.V(5).Infof("CinderMounter: mounting %s to %s", m.VolId, targetDir)
+
+	lock := newVolumeLock(m.CinderConfig, m.VolId)
+	if err := lock.acquire(stateMounting); err != nil {
+		return err
+	}
+	defer lock.release()
+	defer func() {
+		if err != nil {
+			lock.clear()
+		}
+	}()
+
+	if err := m.manager.AttachDisk(m, targetDir); err != nil {
+		glog.V(4).Infof("AttachDisk failed: %v", err)
+		return err
+	}
+	glog.V(3).Infof("Cinder volume %s attached", m.VolId)
+
+	if m.VolumeMode == VolumeModeBlock {
+		if err := m.manager.MountDevice(m, targetDir); err != nil {
+			glog.V(4).Infof("MountDevice failed: %v", err)
+			m.detachDiskLogError()
+			return err
+		}
+	}
+
+	optsData := &flexvolume.FlexVolumeOptsData{
+		CinderData: m.generateOptionsData(),
+	}
+	if err := flexvolume.WriteJsonOptsFile(targetDir, optsData); err != nil {
+		os.Remove(targetDir)
+		m.detachDiskLogError()
+		return err
+	}
+
+	return lock.transition(stateMounted)
+}
+
+// Unmount detaches the Cinder volume previously attached at targetDir and
+// cleans up the metadata file Mount left behind. It runs under the same
+// per-volume lock as Mount, and refuses to run while a mount for the same
+// volume is still in progress. The volume has no state of its own once
+// detached (or once an attempt fails), so the lock's state is cleared on
+// every return path rather than only on success - otherwise a failed
+// detach would wedge the volume at "detaching" for as long as a long-lived
+// caller like the CSI node server keeps the same pid alive.
+func (m *CinderMounter) Unmount(targetDir string) (err error) {
+	glog.V(5).Infof("CinderMounter: unmounting %s", targetDir)
+
+	lock := newVolumeLock(m.CinderConfig, m.VolId)
+	if err := lock.acquire(stateDetaching); err != nil {
+		return err
+	}
+	defer lock.release()
+	defer lock.clear()
+
+	if m.VolumeMode == VolumeModeBlock {
+		if err := m.manager.UnmountDevice(m, targetDir); err != nil {
+			return err
+		}
+	}
+
+	if err := m.manager.DetachDisk(m); err != nil {
+		return err
+	}
+
+	// NOTE: the targetDir will be cleaned by flexvolume/kubelet, we just
+	// need to clean up the metadata file.
+	return flexvolume.CleanUpMetadataFile(targetDir)
+}
+
+func (m *CinderMounter) generateOptionsData() *flexvolume.CinderVolumeOptsData {
+	result := &flexvolume.CinderVolumeOptsData{}
+
+	result.ConfigKey = m.CinderConfig
+	result.VolumeID = m.VolId
+	result.FsType = m.FsType
+	result.VolumeMode = m.VolumeMode
+
+	if data, ok := m.metadata["volume_type"]; ok {
+		result.VolumeType = data.(string)
+	}
+	if data, ok := m.metadata["name"]; ok {
+		result.Name = data.(string)
+	}
+
+	if data, ok := m.metadata["hosts"]; ok {
+		if hosts, err := utilmetadata.ExtractStringSlice(data); err != nil {
+			glog.V(4).Infof("cannot parse metadata hosts: %v", err)
+		} else {
+			result.Hosts = hosts
+		}
+	}
+
+	if data, ok := m.metadata["ports"]; ok {
+		if ports, err := utilmetadata.ExtractStringSlice(data); err != nil {
+			glog.V(4).Infof("cannot parse metadata ports: %v", err)
+		} else {
+			result.Ports = ports
+		}
+	}
+
+	return result
+}
+
+// detachDiskLogError is a wrapper to detach first before log error
+func (m *CinderMounter) detachDiskLogError() {
+	if err := m.manager.DetachDisk(m); err != nil {
+		glog.Warningf("Failed to detach disk %s: %v", m.VolId, err)
+	}
+}